@@ -0,0 +1,23 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// The response returned from a server appending entries to the log.
+type AppendEntriesResponse struct {
+	Term        uint64
+	Success     bool
+	CommitIndex uint64
+
+	// ConflictTerm and ConflictIndex let the leader skip straight back to
+	// the follower's actual point of divergence instead of decrementing
+	// prevLogIndex one entry at a time. ConflictTerm is the term of the
+	// entry the follower had at the rejected prevLogIndex (zero if its log
+	// was simply too short). ConflictIndex is the first index the follower
+	// stores for ConflictTerm, or len(log)+1 when the log was too short.
+	ConflictTerm  uint64
+	ConflictIndex uint64
+}
@@ -0,0 +1,39 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	HeartbeatEventType                = "heartbeat"
+	HeartbeatTimeoutEventType         = "heartbeatTimeout"
+	AppendEntriesRequestEventType     = "appendEntriesRequest"
+	AppendEntriesResponseEventType    = "appendEntriesResponse"
+	SnapshotRequestEventType          = "snapshotRequest"
+	ElectionTimeoutThresholdEventType = "electionTimeoutThreshold"
+	StateChangeEventType              = "stateChange"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// EventListener is called with an Event whenever one matching the type it
+// was registered under is dispatched.
+type EventListener func(Event)
+
+// Event describes a single occurrence in a server or peer's lifecycle, such
+// as a heartbeat or a state change, for delivery to listeners registered
+// with Server.AddEventListener. It exists so metrics and structured logging
+// can observe raft's internals without forking the library.
+type Event struct {
+	Type      string
+	Server    *Server
+	Peer      *Peer
+	PrevValue interface{}
+	Value     interface{}
+}
@@ -0,0 +1,54 @@
+package raft
+
+import "sync"
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// EventDispatcher fans a dispatched Event out to every listener registered
+// for its Type. Server embeds one to expose AddEventListener.
+type EventDispatcher struct {
+	mutex     sync.Mutex
+	listeners map[string][]EventListener
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a new event dispatcher.
+func NewEventDispatcher() *EventDispatcher {
+	return &EventDispatcher{
+		listeners: make(map[string][]EventListener),
+	}
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+// AddEventListener registers a listener to be called whenever an event of
+// the given type is dispatched.
+func (d *EventDispatcher) AddEventListener(eventType string, listener EventListener) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.listeners[eventType] = append(d.listeners[eventType], listener)
+}
+
+// DispatchEvent calls every listener registered for event.Type with event.
+func (d *EventDispatcher) DispatchEvent(event Event) {
+	d.mutex.Lock()
+	listeners := append([]EventListener(nil), d.listeners[event.Type]...)
+	d.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
@@ -0,0 +1,302 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+const (
+	HTTPTransporterAppendEntriesPath = "/appendEntries"
+	HTTPTransporterRequestVotePath   = "/requestVote"
+	HTTPTransporterPreVotePath       = "/preVote"
+	HTTPTransporterSnapshotPath      = "/snapshot"
+	HTTPTransporterSnapshotChunkPath = "/snapshotChunk"
+	HTTPTransporterSnapshotRecovery  = "/snapshotRecovery"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// HTTPMuxer is the interface an application's HTTP router must satisfy for
+// HTTPTransporter to register its RPC handlers on it.
+type HTTPMuxer interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// HTTPTransporter ships raft's RPCs over HTTP: one endpoint per RPC, a
+// shared *http.Transport so all of a peer's calls reuse the same
+// connection pool, and a ResponseHeaderTimeout so a single wedged follower
+// can't block the leader's heartbeat loop forever.
+type HTTPTransporter struct {
+	// DisableKeepAlives disables HTTP keep-alives on the shared transport.
+	DisableKeepAlives bool
+
+	// ResponseHeaderTimeout bounds how long a call waits for the follower's
+	// response headers. If zero when Install is called, it defaults to the
+	// server's election timeout.
+	ResponseHeaderTimeout time.Duration
+
+	prefix     string
+	httpClient http.Client
+	transport  *http.Transport
+}
+
+//------------------------------------------------------------------------------
+//
+// Constructor
+//
+//------------------------------------------------------------------------------
+
+// Creates a new HTTP transporter with the given URL path prefix (e.g. "" or
+// "/raft"). Call Install to register its handlers and pick up its default
+// timeout before using it to send requests.
+func NewHTTPTransporter(prefix string) *HTTPTransporter {
+	return &HTTPTransporter{prefix: prefix}
+}
+
+//------------------------------------------------------------------------------
+//
+// Accessors
+//
+//------------------------------------------------------------------------------
+
+func (t *HTTPTransporter) AppendEntriesPath() string {
+	return t.prefix + HTTPTransporterAppendEntriesPath
+}
+
+func (t *HTTPTransporter) RequestVotePath() string {
+	return t.prefix + HTTPTransporterRequestVotePath
+}
+
+func (t *HTTPTransporter) PreVotePath() string {
+	return t.prefix + HTTPTransporterPreVotePath
+}
+
+func (t *HTTPTransporter) SnapshotPath() string {
+	return t.prefix + HTTPTransporterSnapshotPath
+}
+
+func (t *HTTPTransporter) SnapshotChunkPath() string {
+	return t.prefix + HTTPTransporterSnapshotChunkPath
+}
+
+func (t *HTTPTransporter) SnapshotRecoveryPath() string {
+	return t.prefix + HTTPTransporterSnapshotRecovery
+}
+
+//------------------------------------------------------------------------------
+//
+// Methods
+//
+//------------------------------------------------------------------------------
+
+//--------------------------------------
+// Installation
+//--------------------------------------
+
+// Install registers this transporter's handlers on mux and, if
+// ResponseHeaderTimeout hasn't been set explicitly, defaults it to the
+// server's election timeout.
+func (t *HTTPTransporter) Install(server *Server, mux HTTPMuxer) {
+	if t.ResponseHeaderTimeout == 0 {
+		t.ResponseHeaderTimeout = server.ElectionTimeout()
+	}
+	t.transport = &http.Transport{
+		DisableKeepAlives:     t.DisableKeepAlives,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+	}
+	t.httpClient.Transport = t.transport
+
+	mux.HandleFunc(t.AppendEntriesPath(), t.appendEntriesHandler(server))
+	mux.HandleFunc(t.RequestVotePath(), t.requestVoteHandler(server))
+	mux.HandleFunc(t.PreVotePath(), t.preVoteHandler(server))
+	mux.HandleFunc(t.SnapshotPath(), t.snapshotHandler(server))
+	mux.HandleFunc(t.SnapshotChunkPath(), t.snapshotChunkHandler(server))
+	mux.HandleFunc(t.SnapshotRecoveryPath(), t.snapshotRecoveryHandler(server))
+}
+
+//--------------------------------------
+// Outgoing
+//--------------------------------------
+
+func (t *HTTPTransporter) SendVoteRequest(server *Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse {
+	resp := &RequestVoteResponse{}
+	if err := t.send(context.Background(), peer, t.RequestVotePath(), req, resp); err != nil {
+		return nil
+	}
+	return resp
+}
+
+func (t *HTTPTransporter) SendPreVoteRequest(server *Server, peer *Peer, req *PreVoteRequest) *PreVoteResponse {
+	resp := &PreVoteResponse{}
+	if err := t.send(context.Background(), peer, t.PreVotePath(), req, resp); err != nil {
+		return nil
+	}
+	return resp
+}
+
+func (t *HTTPTransporter) SendAppendEntriesRequest(ctx context.Context, server *Server, peer *Peer, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	resp := &AppendEntriesResponse{}
+	if err := t.send(ctx, peer, t.AppendEntriesPath(), req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransporter) SendSnapshotRequest(ctx context.Context, server *Server, peer *Peer, req *SnapshotRequest) (*SnapshotResponse, error) {
+	resp := &SnapshotResponse{}
+	if err := t.send(ctx, peer, t.SnapshotPath(), req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransporter) SendSnapshotChunk(ctx context.Context, server *Server, peer *Peer, req *SnapshotChunkRequest) (*SnapshotChunkResponse, error) {
+	resp := &SnapshotChunkResponse{}
+	if err := t.send(ctx, peer, t.SnapshotChunkPath(), req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransporter) SendSnapshotRecovery(ctx context.Context, server *Server, peer *Peer, req *SnapshotRecoveryRequest) (*SnapshotRecoveryResponse, error) {
+	resp := &SnapshotRecoveryResponse{}
+	if err := t.send(ctx, peer, t.SnapshotRecoveryPath(), req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// send POSTs req as JSON to path on peer and decodes the reply into resp.
+func (t *HTTPTransporter) send(ctx context.Context, peer *Peer, path string, req interface{}, resp interface{}) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(req); err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest("POST", peer.ConnectionString()+path, &body)
+	if err != nil {
+		return err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := t.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return errStatusFor(httpResp.StatusCode)
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+//--------------------------------------
+// Incoming
+//--------------------------------------
+
+func (t *HTTPTransporter) appendEntriesHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &AppendEntriesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, server.AppendEntries(req))
+	}
+}
+
+func (t *HTTPTransporter) requestVoteHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &RequestVoteRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, server.RequestVote(req))
+	}
+}
+
+func (t *HTTPTransporter) preVoteHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &PreVoteRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, server.RequestPreVote(req))
+	}
+}
+
+func (t *HTTPTransporter) snapshotHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &SnapshotRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, server.Snapshot(req))
+	}
+}
+
+func (t *HTTPTransporter) snapshotChunkHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &SnapshotChunkRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, server.SnapshotChunk(req))
+	}
+}
+
+func (t *HTTPTransporter) snapshotRecoveryHandler(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &SnapshotRecoveryRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, server.SnapshotRecovery(req))
+	}
+}
+
+// writeResponse encodes resp as the handler's reply, or, if the server
+// handler declined to produce one (resp is nil or a nil pointer), replies
+// with a clean 500 instead of sending the JSON encoding "null" as a 200.
+func writeResponse(w http.ResponseWriter, resp interface{}) {
+	if resp == nil || isNilPointer(resp) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// isNilPointer reports whether v holds a nil pointer, which json.Encode
+// would otherwise happily (and uselessly) serialize as "null".
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// errStatusFor turns a non-200 HTTP status from a peer into an error.
+func errStatusFor(statusCode int) error {
+	return fmt.Errorf("raft.HTTPTransporter: Unexpected response status: %d", statusCode)
+}
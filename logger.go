@@ -0,0 +1,39 @@
+package raft
+
+import (
+	"log"
+	"os"
+)
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Logger is the interface raft's internal trace output is written to. It is
+// satisfied by *log.Logger so callers get sensible output by default without
+// having to adapt anything.
+type Logger interface {
+	Println(v ...interface{})
+}
+
+//------------------------------------------------------------------------------
+//
+// Variables
+//
+//------------------------------------------------------------------------------
+
+var logger Logger = log.New(os.Stderr, "[raft] ", log.LstdFlags)
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// SetLogger replaces raft's internal logger, e.g. to silence it or route it
+// through an application's own logging.
+func SetLogger(l Logger) {
+	logger = l
+}
@@ -1,25 +1,71 @@
 package raft
 
 import (
+	"context"
 	"errors"
+	"hash/crc32"
 	"sync"
 	"time"
-	"fmt"
 )
 
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// maxSnapshotChunkRetries is how many times the leader will retry a single
+// chunk, from the last acknowledged offset, before giving up on the
+// snapshot transfer entirely.
+const maxSnapshotChunkRetries = 3
+
+// defaultMaxInFlightAppendEntries bounds how many AppendEntries requests the
+// leader will have outstanding to a single peer at once. This is what lets
+// AppendEntries pipeline instead of waiting a full round-trip per batch.
+const defaultMaxInFlightAppendEntries = 8
+
 //------------------------------------------------------------------------------
 //
 // Typedefs
 //
 //------------------------------------------------------------------------------
 
+// inflightAppendEntries tracks one AppendEntries request the leader has sent
+// but not yet heard back about, so the response handler knows what it
+// confirms once it arrives.
+type inflightAppendEntries struct {
+	prevLogIndex uint64
+	lastIndex    uint64 // 0 if the request carried no entries.
+}
+
 // A peer is a reference to another server involved in the consensus protocol.
 type Peer struct {
-	server         *Server
-	name           string
-	prevLogIndex   uint64
-	mutex          sync.Mutex
+	server           *Server
+	name             string
+	connectionString string
+	nextIndex        uint64 // the next log index to send to this peer.
+	matchIndex       uint64 // the highest log index known to be replicated on this peer.
+	mutex            sync.Mutex
+
 	heartbeatTimer *Timer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg is Add(1)'d in NewPeer and Done()'d when the heartbeat goroutine
+	// exits, so callers that need to know the goroutine has actually
+	// stopped (not just that its context was canceled) can wg.Wait() for
+	// it. Callers stopping several peers at once should cancel every
+	// peer's context first and wg.Wait() once, rather than calling stop()
+	// on each peer in turn, to avoid waiting on a WaitGroup shared with
+	// peers that haven't been canceled yet.
+	wg *sync.WaitGroup
+
+	nextRequestID uint64
+	inflight      map[uint64]*inflightAppendEntries
+	// backtracking is set once a conflict is seen and cleared once matchIndex
+	// catches back up to nextIndex-1. While set, the peer is limited to a
+	// single in-flight AppendEntries so backtracking doesn't race itself.
+	backtracking bool
 }
 
 //------------------------------------------------------------------------------
@@ -28,19 +74,35 @@ type Peer struct {
 //
 //------------------------------------------------------------------------------
 
-// Creates a new peer.
-func NewPeer(server *Server, name string, heartbeatTimeout time.Duration) *Peer {
+// Creates a new peer. ctx governs the peer's lifetime: canceling it (or
+// calling stop(), which cancels a child of it) stops the heartbeat goroutine
+// and unblocks any in-flight RPC the peer is making through the transporter.
+// wg is Add(1)'d before the heartbeat goroutine starts and Done()'d when it
+// exits.
+func NewPeer(server *Server, name string, connectionString string, heartbeatTimeout time.Duration, ctx context.Context, wg *sync.WaitGroup) *Peer {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	peerCtx, cancel := context.WithCancel(ctx)
+
 	p := &Peer{
-		server:         server,
-		name:           name,
-		heartbeatTimer: NewTimer(heartbeatTimeout, heartbeatTimeout),
+		server:           server,
+		name:             name,
+		connectionString: connectionString,
+		nextIndex:        1,
+		heartbeatTimer:   NewTimer(heartbeatTimeout, heartbeatTimeout),
+		inflight:         make(map[uint64]*inflightAppendEntries),
+		ctx:              peerCtx,
+		cancel:           cancel,
+		wg:               wg,
 	}
 
 	// Start the heartbeat timeout and wait for the goroutine to start.
+	wg.Add(1)
 	c := make(chan bool)
 	go p.heartbeatTimeoutFunc(c)
 	<-c
-	
+
 	return p
 }
 
@@ -55,6 +117,12 @@ func (p *Peer) Name() string {
 	return p.name
 }
 
+// Retrieves the address the transporter should connect to in order to
+// reach this peer.
+func (p *Peer) ConnectionString() string {
+	return p.connectionString
+}
+
 // Retrieves the heartbeat timeout.
 func (p *Peer) HeartbeatTimeout() time.Duration {
 	return p.heartbeatTimer.MinDuration()
@@ -65,6 +133,16 @@ func (p *Peer) SetHeartbeatTimeout(duration time.Duration) {
 	p.heartbeatTimer.SetDuration(duration)
 }
 
+// MatchIndex returns the highest log index this peer is known to have
+// replicated. The server uses the majority of peers' match indices to
+// advance the commit index, instead of relying on each peer updating it
+// after the fact.
+func (p *Peer) MatchIndex() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.matchIndex
+}
+
 //------------------------------------------------------------------------------
 //
 // Methods
@@ -89,11 +167,21 @@ func (p *Peer) pause() {
 	p.heartbeatTimer.Pause()
 }
 
-// Stops the peer entirely.
+// Stops the peer entirely: cancels its context, which unblocks the
+// heartbeat loop and any RPC it has in flight, then waits for the
+// heartbeat goroutine to actually exit.
+//
+// cancel() runs before p.mutex is taken: nothing under the lock may block
+// on anything but p.ctx being canceled, so taking the lock afterwards, not
+// before, is what guarantees stop() can't deadlock against it.
 func (p *Peer) stop() {
+	p.cancel()
+
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
 	p.heartbeatTimer.Stop()
+	p.mutex.Unlock()
+
+	p.wg.Wait()
 }
 
 //--------------------------------------
@@ -102,144 +190,351 @@ func (p *Peer) stop() {
 
 // Sends an AppendEntries RPC but does not obtain a lock on the server. This
 // method should only be called from the server.
+//
+// The request is tracked through the same windowed in-flight bookkeeping
+// flushOnHeartbeat uses, and the RPC itself runs without holding p.mutex, so
+// a slow round trip here can't block pipelined sends, MatchIndex(), or
+// stop() the way a single-shot call under the lock would.
 func (p *Peer) internalFlush() (uint64, bool, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	logger.Println("raft.Peer: internal flush: ", p.name)
 
-	fmt.Println("internal flush!")
-	if p.prevLogIndex < p.server.log.StartIndex() {
+	p.mutex.Lock()
+	if p.nextIndex-1 < p.server.log.StartIndex() {
+		p.mutex.Unlock()
 		req := p.server.createSnapshotRequest()
 		return p.sendSnapshotRequest(req)
 	}
-	req := p.server.createInternalAppendEntriesRequest(p.prevLogIndex)
-	return p.sendFlushRequest(req)
+
+	req := p.server.createInternalAppendEntriesRequest(p.nextIndex - 1)
+	requestID := p.nextRequestID
+	p.nextRequestID++
+	info := &inflightAppendEntries{prevLogIndex: p.nextIndex - 1}
+	if len(req.Entries) > 0 {
+		info.lastIndex = req.Entries[len(req.Entries)-1].Index
+	}
+	p.inflight[requestID] = info
+	p.mutex.Unlock()
+
+	return p.sendTrackedAppendEntries(requestID, req, info)
 }
 
-// TODO add this function
-func (p *Peer) sendSnapshotRequest(req *SnapshotRequest) (uint64, bool, error){
-	// Ignore any null requests.
-	if req == nil {
-		return 0, false, errors.New("raft.Peer: Request required")
+// windowSize returns how many AppendEntries requests may be outstanding to
+// this peer at once. It collapses to one while the peer is backtracking so a
+// second in-flight request can't race the conflict resolution.
+func (p *Peer) windowSize() int {
+	if p.backtracking {
+		return 1
 	}
+	return defaultMaxInFlightAppendEntries
+}
 
-	// Generate an snapshot request based on the state of the server and
-	// log. Send the request through the user-provided handler and process the
-	// result.
-	resp, err := p.server.transporter.SendSnapshotRequest(p.server, p, req)
-	p.heartbeatTimer.Reset()
-	if resp == nil {
-		return 0, false, err
+// applyFlushSuccess records that an in-flight AppendEntries request was
+// accepted, advancing matchIndex and, once it has caught back up, clearing
+// backtracking mode.
+func (p *Peer) applyFlushSuccess(info *inflightAppendEntries) {
+	if info.lastIndex > p.matchIndex {
+		p.matchIndex = info.lastIndex
+	}
+	if p.matchIndex+1 > p.nextIndex {
+		p.nextIndex = p.matchIndex + 1
 	}
+	if p.matchIndex >= p.nextIndex-1 {
+		p.backtracking = false
+	}
+}
 
-	// If successful then update the previous log index. If it was
-	// unsuccessful then decrement the previous log index and we'll try again
-	// next time.
-	if resp.Success {
-		p.prevLogIndex = req.LastIndex
-		fmt.Println("update peer preindex to ", p.prevLogIndex)
-	} else {
-		panic(resp)
+// applyFlushFailure applies the fast conflict-term backtracking from the
+// Raft paper's §5.3: jump nextIndex straight back to the follower's actual
+// point of divergence instead of decrementing it one entry at a time.
+func (p *Peer) applyFlushFailure(resp *AppendEntriesResponse) {
+	p.backtracking = true
+
+	lastIndexForTerm := func(term uint64) (uint64, bool) {
+		p.server.log.mutex.Lock()
+		defer p.server.log.mutex.Unlock()
+		return p.server.log.lastIndexForTerm(term)
 	}
+	p.nextIndex = nextIndexAfterConflict(p.nextIndex, p.matchIndex, resp, lastIndexForTerm)
+}
 
-	return resp.Term, resp.Success, err	
+// nextIndexAfterConflict computes the nextIndex a leader should retry a
+// rejected AppendEntries from, given the follower's response. It is the pure
+// decision at the heart of applyFlushFailure, pulled out so the three cases
+// from the Raft paper's §5.3 (follower log shorter than prevLogIndex,
+// conflicting term present in the leader's log, conflicting term absent) can
+// be exercised directly without a *Server to back lastIndexForTerm.
+func nextIndexAfterConflict(nextIndex, matchIndex uint64, resp *AppendEntriesResponse, lastIndexForTerm func(term uint64) (uint64, bool)) uint64 {
+	if resp.ConflictTerm > 0 {
+		if lastIndex, ok := lastIndexForTerm(resp.ConflictTerm); ok {
+			nextIndex = lastIndex + 1
+		} else if resp.ConflictIndex > 0 {
+			nextIndex = resp.ConflictIndex
+		}
+	} else if resp.ConflictIndex > 0 {
+		nextIndex = resp.ConflictIndex
+	} else if nextIndex > 1 {
+		nextIndex--
+	}
+
+	// Don't let it go below where the peer's commit index is though. That's
+	// a problem.
+	if resp.CommitIndex+1 > nextIndex {
+		nextIndex = resp.CommitIndex + 1
+	}
+	if matchIndex+1 > nextIndex {
+		nextIndex = matchIndex + 1
+	}
+	return nextIndex
 }
 
-// Flushes a request through the server's transport.
-func (p *Peer) sendFlushRequest(req *AppendEntriesRequest) (uint64, bool, error) {
+// Streams a snapshot to the peer in fixed-size chunks and, once every chunk
+// has been acknowledged and its checksum verified, tells the follower to
+// install it. The heartbeat timer is held off (paused, not merely reset) for
+// the duration of the transfer so a heartbeat can't interleave with chunks.
+// Callers should not hold p.mutex across this call, since it runs a
+// multi-RPC round trip; matchIndex/nextIndex/backtracking are only touched
+// at the very end, under p.mutex, regardless of what the caller was holding.
+func (p *Peer) sendSnapshotRequest(req *SnapshotRequest) (uint64, bool, error) {
 	// Ignore any null requests.
 	if req == nil {
 		return 0, false, errors.New("raft.Peer: Request required")
 	}
-	fmt.Println("FLUSH: before trans!")
-	// Generate an AppendEntries request based on the state of the server and
-	// log. Send the request through the user-provided handler and process the
-	// result.
-	resp, err := p.server.transporter.SendAppendEntriesRequest(p.server, p, req)
-	fmt.Println("FLUSH: trans finished")
-	p.heartbeatTimer.Reset()
+
+	p.heartbeatTimer.Pause()
+	defer p.heartbeatTimer.Reset()
+
+	p.server.DispatchEvent(Event{Type: SnapshotRequestEventType, Server: p.server, Peer: p, Value: req})
+
+	// Announce the transfer and let the follower accept or reject it based
+	// on term before we ship any state.
+	resp, err := p.server.transporter.SendSnapshotRequest(p.ctx, p.server, p, req)
 	if resp == nil {
-		fmt.Println("trans error")
 		return 0, false, err
 	}
+	if !resp.Success {
+		return resp.Term, false, err
+	}
+
+	if term, success, err := p.sendSnapshotChunks(req); !success {
+		return term, false, err
+	}
+
+	recoveryResp, err := p.server.transporter.SendSnapshotRecovery(p.ctx, p.server, p, &SnapshotRecoveryRequest{
+		LeaderName: req.LeaderName,
+		SnapshotID: req.SnapshotID,
+		Term:       req.Term,
+		LastIndex:  req.LastIndex,
+		LastTerm:   req.LastTerm,
+	})
+	if recoveryResp == nil {
+		return 0, false, err
+	}
+	if !recoveryResp.Success {
+		return recoveryResp.Term, false, err
+	}
 
-	// If successful then update the previous log index. If it was
-	// unsuccessful then decrement the previous log index and we'll try again
-	// next time.
-	if resp.Success {
-		fmt.Println("FLUSH: trans success")
-		if len(req.Entries) > 0 {
-			p.prevLogIndex = req.Entries[len(req.Entries)-1].Index
+	p.mutex.Lock()
+	p.matchIndex = req.LastIndex
+	p.nextIndex = req.LastIndex + 1
+	p.backtracking = false
+	p.mutex.Unlock()
+
+	return recoveryResp.Term, true, nil
+}
+
+// Breaks req.State into fixed-size chunks and sends them sequentially,
+// retrying a failed chunk from the follower's last acknowledged offset
+// rather than restarting or panicking.
+func (p *Peer) sendSnapshotChunks(req *SnapshotRequest) (uint64, bool, error) {
+	checksum := crc32.ChecksumIEEE(req.State)
+
+	var offset uint64
+	for offset < uint64(len(req.State)) || len(req.State) == 0 {
+		end := offset + SnapshotChunkSize
+		if end > uint64(len(req.State)) {
+			end = uint64(len(req.State))
 		}
-	} else {
-		// Decrement the previous log index down until we find a match. Don't
-		// let it go below where the peer's commit index is though. That's a
-		// problem.
-		if p.prevLogIndex > 0 {
-			p.prevLogIndex--
+		done := end >= uint64(len(req.State))
+
+		chunk := &SnapshotChunkRequest{
+			LeaderName: req.LeaderName,
+			SnapshotID: req.SnapshotID,
+			Term:       req.Term,
+			Offset:     offset,
+			Data:       req.State[offset:end],
+			Done:       done,
 		}
-		if resp.CommitIndex > p.prevLogIndex {
-			p.prevLogIndex = resp.CommitIndex
+		if done {
+			chunk.Checksum = checksum
+		}
+
+		var resp *SnapshotChunkResponse
+		var err error
+		for attempt := 0; attempt <= maxSnapshotChunkRetries; attempt++ {
+			resp, err = p.server.transporter.SendSnapshotChunk(p.ctx, p.server, p, chunk)
+			if resp != nil && resp.Success {
+				break
+			}
+			if resp != nil {
+				// Resume from whatever the follower actually has, in case
+				// it is behind where we thought it was.
+				offset = resp.AckOffset
+				chunk.Offset = offset
+				end = offset + SnapshotChunkSize
+				if end > uint64(len(req.State)) {
+					end = uint64(len(req.State))
+				}
+				chunk.Data = req.State[offset:end]
+				done = end >= uint64(len(req.State))
+				chunk.Done = done
+				if done {
+					chunk.Checksum = checksum
+				}
+			}
+		}
+		if resp == nil || !resp.Success {
+			if resp != nil {
+				return resp.Term, false, err
+			}
+			return 0, false, err
+		}
+
+		offset = end
+		if done {
+			return resp.Term, true, nil
 		}
 	}
 
-	return resp.Term, resp.Success, err
+	return 0, true, nil
 }
 
 //--------------------------------------
 // Heartbeat
 //--------------------------------------
 
-// Listens to the heartbeat timeout and flushes an AppendEntries RPC.
+// Listens to the heartbeat timeout and flushes an AppendEntries RPC. Exits
+// as soon as p.ctx is canceled, in addition to the pre-existing closed
+// timer channel exit path.
 func (p *Peer) heartbeatTimeoutFunc(startChannel chan bool) {
+	defer p.wg.Done()
 	startChannel <- true
 
 	for {
 		// Grab the current timer channel.
 		p.mutex.Lock()
-		fmt.Println("heart beat: got lock")
 		var c chan time.Time
 		if p.heartbeatTimer != nil {
 			c = p.heartbeatTimer.C()
 		}
 		p.mutex.Unlock()
-		fmt.Println("heart beat: after lock")
 		// If the channel or timer are gone then exit.
 		if c == nil {
-			fmt.Println("heart beat: break")
 			break
 		}
 
 		// Flush the peer when we get a heartbeat timeout. If the channel is
-		// closed then the peer is getting cleaned up and we should exit.
-		if _, ok := <-c; ok {
-			// Retrieve the peer data within a lock that is separate from the
-			// server lock when creating the request. Otherwise a deadlock can
-			// occur.
-			p.mutex.Lock()
-			server, prevLogIndex := p.server, p.prevLogIndex
-			p.mutex.Unlock()
-			
-			fmt.Println("heart beat, preIndex: ", prevLogIndex, " startIndex:", server.log.StartIndex())
-			
-			server.log.mutex.Lock()
-			if prevLogIndex < server.log.StartIndex() {
-				server.log.mutex.Unlock()
-				req := server.createSnapshotRequest()
-
-				p.mutex.Lock()
-				p.sendSnapshotRequest(req)
-				p.mutex.Unlock()
-			} else {
-
-				// Lock the server to create a request.
-				req := server.createAppendEntriesRequest(prevLogIndex)
-				server.log.mutex.Unlock()
-				p.mutex.Lock()
-				p.sendFlushRequest(req)
-				p.mutex.Unlock()
+		// closed then the peer is getting cleaned up and we should exit. If
+		// the context is canceled first, exit immediately rather than
+		// waiting on a timer that may never fire again.
+		select {
+		case <-p.ctx.Done():
+			return
+		case _, ok := <-c:
+			if !ok {
+				return
+			}
+			p.server.DispatchEvent(Event{Type: HeartbeatTimeoutEventType, Server: p.server, Peer: p})
+			p.flushOnHeartbeat()
+		}
+	}
+}
+
+// flushOnHeartbeat does the work of a single heartbeat tick: sending a
+// snapshot if the peer has fallen behind the log's start, or otherwise
+// dispatching another pipelined AppendEntries request if the in-flight
+// window has room.
+func (p *Peer) flushOnHeartbeat() {
+	p.mutex.Lock()
+	server, nextIndex := p.server, p.nextIndex
+	p.server.DispatchEvent(Event{Type: HeartbeatEventType, Server: p.server, Peer: p, Value: nextIndex})
+
+	server.log.mutex.Lock()
+	if nextIndex-1 < server.log.StartIndex() {
+		server.log.mutex.Unlock()
+		req := server.createSnapshotRequest()
+		// Release p.mutex before the snapshot transfer: it's a multi-RPC,
+		// potentially long-running round trip, and holding the lock across
+		// it would block MatchIndex() and stop() for its entire duration,
+		// which is why internalFlush also sends its RPC outside the lock.
+		p.mutex.Unlock()
+		p.sendSnapshotRequest(req)
+		return
+	}
+
+	// A slow follower shouldn't let the leader allocate unbounded batches to
+	// it, so we only send another request if the in-flight window isn't
+	// already full.
+	if len(p.inflight) >= p.windowSize() {
+		server.log.mutex.Unlock()
+		p.mutex.Unlock()
+		return
+	}
+
+	req := server.createAppendEntriesRequest(nextIndex - 1)
+	server.log.mutex.Unlock()
+
+	requestID := p.nextRequestID
+	p.nextRequestID++
+	info := &inflightAppendEntries{prevLogIndex: nextIndex - 1}
+	if len(req.Entries) > 0 {
+		info.lastIndex = req.Entries[len(req.Entries)-1].Index
+		// Advance nextIndex optimistically so the next tick sends the
+		// following batch instead of resending what's already in flight.
+		// applyFlushFailure rewinds this on conflict.
+		p.nextIndex = info.lastIndex + 1
+	}
+	p.inflight[requestID] = info
+	p.mutex.Unlock()
+
+	go p.sendTrackedAppendEntries(requestID, req, info)
+}
+
+// sendTrackedAppendEntries sends req outside of the peer lock, so multiple
+// requests can be outstanding at once (or, for internalFlush's synchronous
+// caller, so the RPC never blocks pipelined sends, MatchIndex(), or stop()),
+// then applies its response under the lock. Responses to requests that have
+// since been superseded by a conflict are discarded.
+func (p *Peer) sendTrackedAppendEntries(requestID uint64, req *AppendEntriesRequest, info *inflightAppendEntries) (uint64, bool, error) {
+	p.server.DispatchEvent(Event{Type: AppendEntriesRequestEventType, Server: p.server, Peer: p, Value: req})
+	resp, err := p.server.transporter.SendAppendEntriesRequest(p.ctx, p.server, p, req)
+	p.server.DispatchEvent(Event{Type: AppendEntriesResponseEventType, Server: p.server, Peer: p, Value: resp, PrevValue: err})
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.inflight[requestID]; !ok {
+		return 0, false, err
+	}
+	delete(p.inflight, requestID)
+	p.heartbeatTimer.Reset()
+
+	if resp == nil {
+		return 0, false, err
+	}
+
+	if resp.Success {
+		p.applyFlushSuccess(info)
+	} else {
+		p.applyFlushFailure(resp)
+		// The conflict may invalidate batches we already sent for indexes
+		// at or beyond the new nextIndex; drop them so a stale success
+		// can't resurrect state past the point we just backtracked from.
+		for id, other := range p.inflight {
+			if other.prevLogIndex+1 >= p.nextIndex {
+				delete(p.inflight, id)
 			}
-		} else {
-			break
 		}
 	}
+
+	return resp.Term, resp.Success, err
 }
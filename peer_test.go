@@ -0,0 +1,83 @@
+package raft
+
+import "testing"
+
+// TestNextIndexAfterConflict covers the three cases from the Raft paper's
+// §5.3 that applyFlushFailure's fast backtracking is supposed to handle.
+func TestNextIndexAfterConflict(t *testing.T) {
+	tests := []struct {
+		name             string
+		nextIndex        uint64
+		matchIndex       uint64
+		resp             *AppendEntriesResponse
+		lastIndexForTerm func(uint64) (uint64, bool)
+		want             uint64
+	}{
+		{
+			// The follower's log is shorter than prevLogIndex: it has no
+			// entry at all for the term, so it reports ConflictTerm 0 and
+			// ConflictIndex is len(log)+1. The leader should jump straight
+			// there instead of decrementing one entry at a time.
+			name:       "follower log shorter than prevLogIndex",
+			nextIndex:  10,
+			matchIndex: 3,
+			resp:       &AppendEntriesResponse{ConflictTerm: 0, ConflictIndex: 6},
+			lastIndexForTerm: func(uint64) (uint64, bool) {
+				t.Fatal("lastIndexForTerm should not be consulted when ConflictTerm is 0")
+				return 0, false
+			},
+			want: 6,
+		},
+		{
+			// The follower has an entry at prevLogIndex but for a
+			// different term, and the leader's log does contain that
+			// conflicting term: jump to one past the leader's own last
+			// entry for it.
+			name:       "conflicting term present in leader's log",
+			nextIndex:  10,
+			matchIndex: 3,
+			resp:       &AppendEntriesResponse{ConflictTerm: 4, ConflictIndex: 6},
+			lastIndexForTerm: func(term uint64) (uint64, bool) {
+				if term != 4 {
+					t.Fatalf("lastIndexForTerm called with term %d, want 4", term)
+				}
+				return 7, true
+			},
+			want: 8,
+		},
+		{
+			// The leader's log has no entries for the follower's
+			// conflicting term at all (it was from a different leader's
+			// aborted run): fall back to the follower's ConflictIndex.
+			name:       "conflicting term absent from leader's log",
+			nextIndex:  10,
+			matchIndex: 3,
+			resp:       &AppendEntriesResponse{ConflictTerm: 4, ConflictIndex: 6},
+			lastIndexForTerm: func(term uint64) (uint64, bool) {
+				return 0, false
+			},
+			want: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextIndexAfterConflict(tt.nextIndex, tt.matchIndex, tt.resp, tt.lastIndexForTerm)
+			if got != tt.want {
+				t.Errorf("nextIndexAfterConflict() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNextIndexAfterConflictNeverBelowCommitOrMatch checks the two floors
+// applied after the conflict-term logic: nextIndex never drops below the
+// follower's reported commit index, nor below the leader's own matchIndex
+// for this peer.
+func TestNextIndexAfterConflictNeverBelowCommitOrMatch(t *testing.T) {
+	resp := &AppendEntriesResponse{ConflictTerm: 0, ConflictIndex: 2, CommitIndex: 5}
+	got := nextIndexAfterConflict(10, 7, resp, nil)
+	if want := uint64(8); got != want {
+		t.Errorf("nextIndexAfterConflict() = %d, want %d", got, want)
+	}
+}
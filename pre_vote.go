@@ -0,0 +1,46 @@
+package raft
+
+import "time"
+
+//------------------------------------------------------------------------------
+//
+// Functions
+//
+//------------------------------------------------------------------------------
+
+// ShouldGrantPreVote decides whether a follower should grant a PreVoteRequest.
+// It is the decision the HTTP handler's call into Server.RequestPreVote is
+// expected to make before answering: grant only if the candidate's log is at
+// least as up to date as the follower's own, and only if the follower hasn't
+// heard from a valid leader within the election timeout. The second check is
+// what keeps a partitioned node's pre-vote probes from disrupting a cluster
+// that already has a working leader.
+func ShouldGrantPreVote(req *PreVoteRequest, selfLastLogIndex, selfLastLogTerm uint64, timeSinceLastLeaderContact, electionTimeout time.Duration) bool {
+	if timeSinceLastLeaderContact < electionTimeout {
+		return false
+	}
+	return isLogAtLeastAsUpToDate(req.LastLogTerm, req.LastLogIndex, selfLastLogTerm, selfLastLogIndex)
+}
+
+// isLogAtLeastAsUpToDate applies the Raft paper's §5.4.1 log comparison: the
+// log with the later term is more up to date, and within the same term the
+// log with the higher index is more up to date. It's shared, in spirit, with
+// the comparison a real RequestVote grant would make.
+func isLogAtLeastAsUpToDate(candidateLastLogTerm, candidateLastLogIndex, selfLastLogTerm, selfLastLogIndex uint64) bool {
+	if candidateLastLogTerm != selfLastLogTerm {
+		return candidateLastLogTerm > selfLastLogTerm
+	}
+	return candidateLastLogIndex >= selfLastLogIndex
+}
+
+// HasPreVoteMajority reports whether granted pre-votes, plus the candidate's
+// implicit vote for itself, form a majority of a cluster with the given
+// number of peers (not counting the candidate). A candidate should only bump
+// its term and start a real election once this returns true; gating the
+// term increment behind it is what keeps a partitioned node's term from
+// running away while it can't reach anyone.
+func HasPreVoteMajority(granted, peerCount int) bool {
+	clusterSize := peerCount + 1
+	votes := granted + 1
+	return votes*2 > clusterSize
+}
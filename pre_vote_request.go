@@ -0,0 +1,25 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A PreVoteRequest asks a peer whether it would vote for the candidate if
+// the candidate went on to start a real election at Term. Unlike
+// RequestVoteRequest, granting one does not persist anything on the
+// receiver and does not bump its term, so a partitioned node probing for
+// pre-votes can't force a healthy cluster to churn.
+type PreVoteRequest struct {
+	Term          uint64
+	CandidateName string
+	LastLogIndex  uint64
+	LastLogTerm   uint64
+}
+
+// The response to a PreVoteRequest.
+type PreVoteResponse struct {
+	Term        uint64
+	VoteGranted bool
+}
@@ -0,0 +1,43 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Constants
+//
+//------------------------------------------------------------------------------
+
+// SnapshotChunkSize is the maximum amount of snapshot state sent in a single
+// SnapshotChunkRequest.
+const SnapshotChunkSize = 64 * 1024
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// A single piece of a snapshot transfer. Chunks for a given SnapshotID must
+// be applied in offset order; the follower discards the transfer if it sees
+// a SnapshotID or Term it doesn't recognize as current.
+type SnapshotChunkRequest struct {
+	LeaderName string
+	SnapshotID string
+	Term       uint64
+	Offset     uint64
+	Data       []byte
+	Done       bool
+	// Checksum is the CRC32 (IEEE) of the full snapshot state. It is only
+	// set on the final chunk and is verified before the snapshot is handed
+	// off to SnapshotRecoveryRequest.
+	Checksum uint32
+}
+
+// The response to a SnapshotChunkRequest.
+type SnapshotChunkResponse struct {
+	Term    uint64
+	Success bool
+	// AckOffset is the offset of the last chunk the follower has durably
+	// received for this SnapshotID. On failure the leader resumes sending
+	// from this offset instead of restarting the whole transfer.
+	AckOffset uint64
+}
@@ -0,0 +1,25 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Sent once all of a snapshot's chunks have been acknowledged and their
+// checksum verified, instructing the follower to install the snapshot it has
+// just finished receiving and catch its log up to LastIndex/LastTerm.
+type SnapshotRecoveryRequest struct {
+	LeaderName string
+	SnapshotID string
+	Term       uint64
+	LastIndex  uint64
+	LastTerm   uint64
+}
+
+// The response to a SnapshotRecoveryRequest.
+type SnapshotRecoveryResponse struct {
+	Term        uint64
+	Success     bool
+	CommitIndex uint64
+}
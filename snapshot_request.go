@@ -0,0 +1,32 @@
+package raft
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// The request sent to a follower to announce that a snapshot transfer is
+// about to begin. It carries only the metadata needed for the follower to
+// decide whether to accept the upcoming chunks; the state itself is streamed
+// separately via SnapshotChunkRequest.
+type SnapshotRequest struct {
+	LeaderName string
+	SnapshotID string
+	Term       uint64
+	LastIndex  uint64
+	LastTerm   uint64
+
+	// State is the serialized state machine snapshot. It never goes over
+	// the wire as part of this request; it is only carried here so the
+	// leader can slice it into SnapshotChunkRequests once the follower has
+	// agreed to receive the transfer. json:"-" enforces that at the
+	// transporter boundary instead of relying on callers to remember it.
+	State []byte `json:"-"`
+}
+
+// The response to a SnapshotRequest.
+type SnapshotResponse struct {
+	Term    uint64
+	Success bool
+}
@@ -0,0 +1,22 @@
+package raft
+
+import "context"
+
+//------------------------------------------------------------------------------
+//
+// Typedefs
+//
+//------------------------------------------------------------------------------
+
+// Transporter is the interface for allowing the host application to transport
+// requests to other nodes. The AppendEntries and snapshot calls take a
+// context so a shutting-down peer can cancel an in-flight call instead of
+// blocking on a stuck transport.
+type Transporter interface {
+	SendVoteRequest(server *Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse
+	SendPreVoteRequest(server *Server, peer *Peer, req *PreVoteRequest) *PreVoteResponse
+	SendAppendEntriesRequest(ctx context.Context, server *Server, peer *Peer, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	SendSnapshotRequest(ctx context.Context, server *Server, peer *Peer, req *SnapshotRequest) (*SnapshotResponse, error)
+	SendSnapshotChunk(ctx context.Context, server *Server, peer *Peer, req *SnapshotChunkRequest) (*SnapshotChunkResponse, error)
+	SendSnapshotRecovery(ctx context.Context, server *Server, peer *Peer, req *SnapshotRecoveryRequest) (*SnapshotRecoveryResponse, error)
+}